@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+// defaultHistoryDepth is how many pre-step snapshots the `debug` subcommand
+// asks newDebugger to keep for "back".
+const defaultHistoryDepth = 1000
+
+// vmSnapshot is a full copy of VM state taken before a single Step, used by
+// the debugger's "back" command to undo execution. memory is a Memory
+// clone, so it shares unmodified pages with the live VM until one of them
+// writes to a page, keeping a snapshot per step cheap.
+type vmSnapshot struct {
+	registers [8]uint16
+	stack     []uint16
+	memory    *Memory
+	pc        uint16
+	input     string
+}
+
+func snapshotVM(vm *VM) vmSnapshot {
+	return vmSnapshot{
+		registers: vm.registers,
+		stack:     vm.stack.Snapshot(),
+		memory:    vm.memory.Clone(),
+		pc:        vm.pc,
+		input:     vm.input,
+	}
+}
+
+func (s vmSnapshot) restore(vm *VM) {
+	vm.registers = s.registers
+	vm.stack.Restore(append([]uint16(nil), s.stack...))
+	vm.memory = s.memory.Clone()
+	vm.pc = s.pc
+	vm.input = s.input
+}
+
+// history is a fixed-size ring buffer of pre-step snapshots. Pushing past
+// its capacity silently drops the oldest entry, so "back" can only reach as
+// far as the configured depth.
+type history struct {
+	snapshots []vmSnapshot
+	capacity  int
+}
+
+func newHistory(capacity int) *history {
+	return &history{capacity: capacity}
+}
+
+func (h *history) push(s vmSnapshot) {
+	h.snapshots = append(h.snapshots, s)
+
+	if len(h.snapshots) > h.capacity {
+		h.snapshots = h.snapshots[1:]
+	}
+}
+
+// back returns the snapshot taken n steps ago, and trims the ring so that
+// replaying from there doesn't replay stale future entries.
+func (h *history) back(n int) (vmSnapshot, bool) {
+	if n <= 0 || n > len(h.snapshots) {
+		return vmSnapshot{}, false
+	}
+
+	idx := len(h.snapshots) - n
+	s := h.snapshots[idx]
+	h.snapshots = h.snapshots[:idx]
+
+	return s, true
+}
+
+// watch tracks a register or memory address across steps so the debugger
+// can report when its value changes.
+type watch struct {
+	isRegister bool
+	addr       uint16
+	last       uint16
+	have       bool
+}
+
+func (w *watch) read(vm *VM) uint16 {
+	if w.isRegister {
+		return vm.registers[w.addr]
+	}
+
+	return vm.memory.Read(w.addr)
+}
+
+// debugger wraps a VM with breakpoints, watchpoints and reverse-step,
+// dropping into an interactive REPL when a breakpoint fires or the user
+// sends Ctrl-C. It replaces the old `debug bool` printf toggle with an
+// environment suitable for reversing self-modifying code like the
+// teleporter check.
+type debugger struct {
+	vm          *VM
+	breakpoints map[uint16]bool
+	watches     []*watch
+	history     *history
+	in          *bufio.Scanner
+	interrupt   chan os.Signal
+}
+
+// newDebugger wraps vm for interactive debugging, keeping the last
+// historyDepth pre-step snapshots available for "back".
+func newDebugger(vm *VM, historyDepth int) *debugger {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	return &debugger{
+		vm:          vm,
+		breakpoints: make(map[uint16]bool),
+		history:     newHistory(historyDepth),
+		in:          bufio.NewScanner(os.Stdin),
+		interrupt:   interrupt,
+	}
+}
+
+// Run drives the VM to completion, pausing into the REPL on breakpoints or
+// Ctrl-C.
+func (d *debugger) Run() {
+	fmt.Println("entering debugger; type 'help' for commands")
+	d.repl()
+
+	for {
+		select {
+		case <-d.interrupt:
+			fmt.Println("\ninterrupted")
+			d.repl()
+		default:
+		}
+
+		if d.breakpoints[d.vm.pc] {
+			fmt.Printf("breakpoint hit at %05d\n", d.vm.pc)
+			d.repl()
+		}
+
+		d.history.push(snapshotVM(d.vm))
+
+		halted, err := stepVM(d.vm)
+
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			d.repl()
+			continue
+		}
+
+		if halted {
+			fmt.Println("\nprogram halted")
+			return
+		}
+
+		d.reportWatches()
+	}
+}
+
+func (d *debugger) reportWatches() {
+	for _, w := range d.watches {
+		v := w.read(d.vm)
+
+		if w.have && v != w.last {
+			kind := "mem"
+			if w.isRegister {
+				kind = "reg"
+			}
+
+			fmt.Printf("watch: %s %d changed %d -> %d\n", kind, w.addr, w.last, v)
+		}
+
+		w.last = v
+		w.have = true
+	}
+}
+
+// repl reads and executes debugger commands until "continue" or the end of
+// input; "step" executes one instruction and stays in the REPL.
+func (d *debugger) repl() {
+	for {
+		fmt.Print("(dbg) ")
+
+		if !d.in.Scan() {
+			return
+		}
+
+		fields := strings.Fields(d.in.Text())
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "help":
+			fmt.Println("break <addr> | watch reg <n>|mem <addr> | step | continue | regs | stack | mem <addr> <len> | disasm <addr> | back <n>")
+		case "break":
+			if addr, ok := parseAddr(fields, 1); ok {
+				d.breakpoints[addr] = true
+				fmt.Printf("breakpoint set at %05d\n", addr)
+			}
+		case "watch":
+			d.cmdWatch(fields)
+		case "step":
+			d.history.push(snapshotVM(d.vm))
+
+			if _, err := stepVM(d.vm); err != nil {
+				fmt.Printf("error: %v\n", err)
+				continue
+			}
+
+			d.reportWatches()
+			fmt.Printf("now at %05d\n", d.vm.pc)
+		case "continue":
+			return
+		case "regs":
+			fmt.Println(d.vm.registers)
+		case "stack":
+			fmt.Println(d.vm.stack.Snapshot())
+		case "mem":
+			d.cmdMem(fields)
+		case "disasm":
+			d.cmdDisasm(fields)
+		case "back":
+			d.cmdBack(fields)
+		default:
+			fmt.Printf("unknown command %q\n", fields[0])
+		}
+	}
+}
+
+func (d *debugger) cmdWatch(fields []string) {
+	if len(fields) < 3 {
+		fmt.Println("usage: watch reg <n>|mem <addr>")
+		return
+	}
+
+	addr, err := strconv.ParseUint(fields[2], 10, 16)
+
+	if err != nil {
+		fmt.Printf("bad address %q\n", fields[2])
+		return
+	}
+
+	w := &watch{isRegister: fields[1] == "reg", addr: uint16(addr)}
+	w.last, w.have = w.read(d.vm), true
+	d.watches = append(d.watches, w)
+	fmt.Printf("watching %s %d\n", fields[1], addr)
+}
+
+func (d *debugger) cmdMem(fields []string) {
+	if len(fields) < 3 {
+		fmt.Println("usage: mem <addr> <len>")
+		return
+	}
+
+	addr, ok1 := parseAddr(fields, 1)
+	length, err := strconv.Atoi(fields[2])
+
+	if !ok1 || err != nil {
+		fmt.Println("usage: mem <addr> <len>")
+		return
+	}
+
+	words := d.vm.memory.Words()
+
+	if int(addr) >= len(words) {
+		fmt.Printf("address %d is out of range (0-%d)\n", addr, len(words)-1)
+		return
+	}
+
+	end := int(addr) + length
+
+	if end > len(words) {
+		end = len(words)
+	}
+
+	fmt.Println(words[addr:end])
+}
+
+func (d *debugger) cmdDisasm(fields []string) {
+	addr, ok := parseAddr(fields, 1)
+
+	if !ok {
+		fmt.Println("usage: disasm <addr>")
+		return
+	}
+
+	inst, ok := decodeInstruction(d.vm.memory.Words(), addr)
+
+	if !ok {
+		fmt.Println("not a decodable instruction")
+		return
+	}
+
+	fmt.Println(formatInstruction(inst, false))
+}
+
+func (d *debugger) cmdBack(fields []string) {
+	n := 1
+
+	if len(fields) > 1 {
+		parsed, err := strconv.Atoi(fields[1])
+
+		if err != nil {
+			fmt.Println("usage: back <n>")
+			return
+		}
+
+		n = parsed
+	}
+
+	snap, ok := d.history.back(n)
+
+	if !ok {
+		fmt.Printf("cannot go back %d steps (history depth %d)\n", n, d.history.capacity)
+		return
+	}
+
+	snap.restore(d.vm)
+	fmt.Printf("rewound to %05d\n", d.vm.pc)
+}
+
+func parseAddr(fields []string, i int) (uint16, bool) {
+	if i >= len(fields) {
+		return 0, false
+	}
+
+	v, err := strconv.ParseUint(fields[i], 10, 16)
+
+	if err != nil {
+		return 0, false
+	}
+
+	return uint16(v), true
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// opcodeInfo describes how many operands an opcode takes and its mnemonic,
+// mirroring the switch in VM.Step in vm.go.
+type opcodeInfo struct {
+	mnemonic string
+	operands int
+}
+
+var opcodes = map[uint16]opcodeInfo{
+	0:  {"halt", 0},
+	1:  {"set", 2},
+	2:  {"push", 1},
+	3:  {"pop", 1},
+	4:  {"eq", 3},
+	5:  {"gt", 3},
+	6:  {"jmp", 1},
+	7:  {"jt", 2},
+	8:  {"jf", 2},
+	9:  {"add", 3},
+	10: {"mult", 3},
+	11: {"mod", 3},
+	12: {"and", 3},
+	13: {"or", 3},
+	14: {"not", 2},
+	15: {"rmem", 2},
+	16: {"wmem", 2},
+	17: {"call", 1},
+	18: {"ret", 0},
+	19: {"out", 1},
+	20: {"in", 1},
+	21: {"noop", 0},
+}
+
+// instruction is a single decoded instruction at a given address.
+type instruction struct {
+	addr     uint16
+	opcode   uint16
+	mnemonic string
+	args     []uint16
+	length   uint16 // total words including the opcode itself
+}
+
+// operand renders a raw instruction word as either a literal or a register
+// name (R0..R7), matching the encoding getValue understands.
+func operand(v uint16) string {
+	if v < 32768 {
+		return fmt.Sprintf("%d", v)
+	}
+
+	if v > 32775 {
+		return fmt.Sprintf("<invalid:%d>", v)
+	}
+
+	return fmt.Sprintf("R%d", v-32768)
+}
+
+// decodeInstruction decodes the instruction at addr. It returns ok=false if
+// addr is out of range or names an unknown opcode, in which case it should
+// be treated as data rather than code.
+func decodeInstruction(program []uint16, addr uint16) (instruction, bool) {
+	if int(addr) >= len(program) {
+		return instruction{}, false
+	}
+
+	op := program[addr]
+	info, known := opcodes[op]
+
+	if !known {
+		return instruction{}, false
+	}
+
+	if int(addr)+info.operands >= len(program) {
+		return instruction{}, false
+	}
+
+	args := make([]uint16, info.operands)
+	for i := 0; i < info.operands; i++ {
+		args[i] = program[int(addr)+1+i]
+	}
+
+	return instruction{
+		addr:     addr,
+		opcode:   op,
+		mnemonic: info.mnemonic,
+		args:     args,
+		length:   uint16(info.operands + 1),
+	}, true
+}
+
+// listing is the result of walking the control-flow graph from entry: the
+// set of reachable instructions plus the addresses later overwritten by
+// wmem while still reachable, which marks them as self-modifying.
+type listing struct {
+	instructions map[uint16]instruction
+	order        []uint16
+	selfModified map[uint16]bool
+}
+
+// disassemble follows control flow from addr 0, decoding every reachable
+// instruction and resolving call/jmp/jt/jf targets into further work items.
+// Addresses it never reaches are left undecoded (data, strings, the stack
+// area, etc).
+func disassemble(program []uint16) listing {
+	result := listing{
+		instructions: make(map[uint16]instruction),
+		selfModified: make(map[uint16]bool),
+	}
+
+	visited := make(map[uint16]bool)
+	queue := []uint16{0}
+
+	for len(queue) > 0 {
+		addr := queue[0]
+		queue = queue[1:]
+
+		if visited[addr] {
+			continue
+		}
+
+		inst, ok := decodeInstruction(program, addr)
+		if !ok {
+			continue
+		}
+
+		visited[addr] = true
+		result.instructions[addr] = inst
+		result.order = append(result.order, addr)
+
+		switch inst.opcode {
+		case 0, 18: // halt, ret: no fall-through, no literal successor
+			continue
+		case 6: // jmp <a>
+			if target, ok := literalTarget(inst.args[0]); ok {
+				queue = append(queue, target)
+			}
+			continue
+		case 7, 8: // jt/jf <a> <b>: may fall through or branch
+			if target, ok := literalTarget(inst.args[1]); ok {
+				queue = append(queue, target)
+			}
+		case 17: // call <a>: control returns here eventually via ret, keep walking
+			if target, ok := literalTarget(inst.args[0]); ok {
+				queue = append(queue, target)
+			}
+		case 16: // wmem <a> <b>: flag self-modifying code if <a> is a reachable address
+			if target, ok := literalTarget(inst.args[0]); ok {
+				result.selfModified[target] = true
+			}
+		}
+
+		queue = append(queue, addr+inst.length)
+	}
+
+	for target := range result.selfModified {
+		if _, reachable := result.instructions[target]; !reachable {
+			delete(result.selfModified, target)
+		}
+	}
+
+	sort.Slice(result.order, func(i, j int) bool { return result.order[i] < result.order[j] })
+
+	return result
+}
+
+func literalTarget(v uint16) (uint16, bool) {
+	if v < 32768 {
+		return v, true
+	}
+
+	return 0, false
+}
+
+// formatInstruction renders one instruction as a flat-text listing line,
+// including inline ASCII for literal `out` arguments.
+func formatInstruction(inst instruction, selfModified bool) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%05d: %s", inst.addr, inst.mnemonic)
+
+	for _, a := range inst.args {
+		fmt.Fprintf(&b, " %s", operand(a))
+	}
+
+	if inst.mnemonic == "out" && inst.args[0] < 128 {
+		fmt.Fprintf(&b, "  ; %q", rune(inst.args[0]))
+	}
+
+	if selfModified {
+		b.WriteString("  ; self-modified")
+	}
+
+	return b.String()
+}
+
+// basicBlocks groups a listing's reachable instructions into labeled blocks,
+// splitting wherever control transfers (jmp/jt/jf/call/ret/halt) or a jump
+// target lands mid-stream.
+func basicBlocks(l listing) [][]uint16 {
+	leaders := map[uint16]bool{}
+	if len(l.order) > 0 {
+		leaders[l.order[0]] = true
+	}
+
+	for _, addr := range l.order {
+		inst := l.instructions[addr]
+
+		switch inst.opcode {
+		case 6, 7, 8, 17:
+			dest := inst.args[0]
+			if inst.opcode == 7 || inst.opcode == 8 {
+				dest = inst.args[1] // jt/jf: args[0] is the condition
+			}
+
+			if target, ok := literalTarget(dest); ok {
+				if _, exists := l.instructions[target]; exists {
+					leaders[target] = true
+				}
+			}
+
+			leaders[addr+inst.length] = true
+		case 0, 18:
+			leaders[addr+inst.length] = true
+		}
+	}
+
+	var blocks [][]uint16
+	var current []uint16
+
+	for _, addr := range l.order {
+		if leaders[addr] && len(current) > 0 {
+			blocks = append(blocks, current)
+			current = nil
+		}
+
+		current = append(current, addr)
+	}
+
+	if len(current) > 0 {
+		blocks = append(blocks, current)
+	}
+
+	return blocks
+}
+
+// writeListing prints the flat text disassembly to stdout.
+func writeListing(l listing) {
+	for _, addr := range l.order {
+		fmt.Println(formatInstruction(l.instructions[addr], l.selfModified[addr]))
+	}
+}
+
+// writeCFG prints a Graphviz dot dump of the control-flow graph: one node
+// per basic block, one edge per resolved jmp/jt/jf/call target.
+func writeCFG(l listing) {
+	blocks := basicBlocks(l)
+	blockOf := map[uint16]int{}
+
+	for i, block := range blocks {
+		blockOf[block[0]] = i
+	}
+
+	fmt.Println("digraph cfg {")
+	fmt.Println("  node [shape=box, fontname=monospace];")
+
+	for i, block := range blocks {
+		fmt.Printf("  b%d [label=\"block_%05d\\n", i, block[0])
+
+		for _, addr := range block {
+			fmt.Printf("%s\\n", strings.ReplaceAll(formatInstruction(l.instructions[addr], l.selfModified[addr]), "\"", "'"))
+		}
+
+		fmt.Println("\"];")
+	}
+
+	for i, block := range blocks {
+		last := l.instructions[block[len(block)-1]]
+
+		switch last.opcode {
+		case 6:
+			if target, ok := literalTarget(last.args[0]); ok {
+				if j, ok := blockOf[target]; ok {
+					fmt.Printf("  b%d -> b%d;\n", i, j)
+				}
+			}
+		case 7, 8:
+			if target, ok := literalTarget(last.args[1]); ok {
+				if j, ok := blockOf[target]; ok {
+					fmt.Printf("  b%d -> b%d [label=\"branch\"];\n", i, j)
+				}
+			}
+
+			if i+1 < len(blocks) {
+				fmt.Printf("  b%d -> b%d [label=\"fallthrough\"];\n", i, i+1)
+			}
+		case 17:
+			if target, ok := literalTarget(last.args[0]); ok {
+				if j, ok := blockOf[target]; ok {
+					fmt.Printf("  b%d -> b%d [label=\"call\"];\n", i, j)
+				}
+			}
+
+			if i+1 < len(blocks) {
+				fmt.Printf("  b%d -> b%d;\n", i, i+1)
+			}
+		case 0, 18:
+			// no outgoing edges
+		default:
+			if i+1 < len(blocks) {
+				fmt.Printf("  b%d -> b%d;\n", i, i+1)
+			}
+		}
+	}
+
+	fmt.Println("}")
+}
+
+// runDisasm implements the `disasm` subcommand: `program disasm [-cfg]`.
+func runDisasm(program []uint16, args []string) {
+	l := disassemble(program)
+
+	for _, arg := range args {
+		if arg == "-cfg" {
+			writeCFG(l)
+			return
+		}
+	}
+
+	writeListing(l)
+}
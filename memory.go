@@ -0,0 +1,127 @@
+package main
+
+import "fmt"
+
+// pageSize is the granularity at which Memory pages are allocated and
+// copy-on-write shared between snapshots.
+const pageSize = 4096
+
+// memorySize is the full 15-bit address space the VM can address.
+const memorySize = 32768
+
+const numPages = (memorySize + pageSize - 1) / pageSize
+
+type page [pageSize]uint16
+
+// MemoryFault is raised instead of a raw Go index panic whenever an
+// address outside the 15-bit address space is read or written, so callers
+// can recover it and report something more useful than "index out of
+// range".
+type MemoryFault struct {
+	Addr uint16
+	PC   uint16
+}
+
+func (f MemoryFault) Error() string {
+	return fmt.Sprintf("memory fault: address %d out of range (pc=%d)", f.Addr, f.PC)
+}
+
+// Memory is the VM's 32768-word address space, backed by lazily allocated
+// 4KB pages. Clone() shares every page between the original and the copy
+// until one of them writes to it, at which point that page is duplicated -
+// this is what makes debugger snapshots (see history in debugger.go) cheap
+// even though the address space as a whole is large.
+type Memory struct {
+	pages     [numPages]*page
+	exclusive [numPages]bool
+	pc        uint16 // most recent PC, attached to MemoryFault on out-of-range access
+}
+
+// NewMemory creates a Memory with words loaded at address 0 and the rest of
+// the address space zeroed.
+func NewMemory(words []uint16) *Memory {
+	m := &Memory{}
+
+	for addr := 0; addr < len(words) && addr < memorySize; addr++ {
+		m.Write(uint16(addr), words[addr])
+	}
+
+	return m
+}
+
+// SetPC records the VM's current program counter so a subsequent
+// MemoryFault can report where the offending access happened.
+func (m *Memory) SetPC(pc uint16) {
+	m.pc = pc
+}
+
+func (m *Memory) fault(addr uint16) {
+	panic(MemoryFault{Addr: addr, PC: m.pc})
+}
+
+// Read returns the word at addr, or 0 if that page has never been written.
+func (m *Memory) Read(addr uint16) uint16 {
+	if addr >= memorySize {
+		m.fault(addr)
+	}
+
+	p := m.pages[addr/pageSize]
+
+	if p == nil {
+		return 0
+	}
+
+	return p[addr%pageSize]
+}
+
+// Write stores val at addr, copy-on-write duplicating the backing page
+// first if it's still shared with another Memory from a Clone.
+func (m *Memory) Write(addr, val uint16) {
+	if addr >= memorySize {
+		m.fault(addr)
+	}
+
+	idx := addr / pageSize
+
+	switch {
+	case m.pages[idx] == nil:
+		m.pages[idx] = &page{}
+		m.exclusive[idx] = true
+	case !m.exclusive[idx]:
+		cp := *m.pages[idx]
+		m.pages[idx] = &cp
+		m.exclusive[idx] = true
+	}
+
+	m.pages[idx][addr%pageSize] = val
+}
+
+// Clone returns an independent copy of m that shares unmodified pages with
+// it; both m and the returned copy duplicate a page on their first write to
+// it rather than up front.
+func (m *Memory) Clone() *Memory {
+	clone := &Memory{pages: m.pages, pc: m.pc}
+
+	for i := range m.exclusive {
+		m.exclusive[i] = false
+	}
+
+	return clone
+}
+
+// Words materializes the full address space as a flat slice, for callers
+// like the disassembler and the debugger's `mem`/`disasm` commands that
+// want to read a range rather than one word at a time.
+func (m *Memory) Words() []uint16 {
+	words := make([]uint16, memorySize)
+
+	for i, p := range m.pages {
+		if p == nil {
+			continue
+		}
+
+		copy(words[i*pageSize:], p[:])
+	}
+
+	return words
+}
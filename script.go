@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// scriptItem is one line of a script file, already classified.
+type scriptItem struct {
+	kind string // "input", "wait", "assert", "expect", "load"
+	args []string
+}
+
+// parseScript reads a script file into an ordered list of items. Plain
+// lines become "input" items; "#" lines are comments and are dropped;
+// "!wait", "!assert", "!expect" and "!load" lines become directives.
+func parseScript(path string) ([]scriptItem, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var items []scriptItem
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			fields := strings.Fields(line)
+			items = append(items, scriptItem{kind: strings.TrimPrefix(fields[0], "!"), args: fields[1:]})
+			continue
+		}
+
+		items = append(items, scriptItem{kind: "input", args: []string{line}})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// maxScriptSteps bounds how many instructions runScript will execute while
+// waiting for a `!wait` target or draining a line of input, so a script
+// that desyncs from the VM fails loudly instead of hanging forever.
+const maxScriptSteps = 50_000_000
+
+// runScript drives vm through the script at path, a line at a time, and
+// returns an error describing the first directive or input line that
+// didn't hold.
+func runScript(vm *VM, path string) error {
+	items, err := parseScript(path)
+
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		switch item.kind {
+		case "input":
+			if err := feedLine(vm, item.args[0]+"\n"); err != nil {
+				return err
+			}
+		case "wait":
+			if len(item.args) < 1 {
+				return fmt.Errorf("!wait needs an address")
+			}
+
+			addr, err := strconv.ParseUint(item.args[0], 10, 16)
+
+			if err != nil {
+				return fmt.Errorf("!wait: bad address %q", item.args[0])
+			}
+
+			if err := waitFor(vm, uint16(addr)); err != nil {
+				return err
+			}
+		case "assert":
+			if err := assertRegister(vm, item.args); err != nil {
+				return err
+			}
+		case "expect":
+			if len(item.args) < 1 {
+				return fmt.Errorf("!expect needs a substring")
+			}
+
+			want := strings.Join(item.args, " ")
+
+			if !strings.Contains(string(vm.recentOutput), want) {
+				return fmt.Errorf("!expect %q: not seen in recent output", want)
+			}
+		case "load":
+			if len(item.args) < 1 {
+				return fmt.Errorf("!load needs a snapshot file")
+			}
+
+			file, err := os.Open(item.args[0])
+
+			if err != nil {
+				return fmt.Errorf("!load %s: %w", item.args[0], err)
+			}
+
+			err = vm.Load(file)
+			file.Close()
+
+			if err != nil {
+				return fmt.Errorf("!load %s: %w", item.args[0], err)
+			}
+		default:
+			return fmt.Errorf("unknown directive !%s", item.kind)
+		}
+	}
+
+	return nil
+}
+
+// stepVM steps vm, recovering any panic - most commonly fillInput's when
+// the script runner's readLine reports input exhaustion, but also a stack
+// underflow or unknown opcode - into a plain error. Without this, a script
+// that runs the VM past what it supplied would crash the whole process
+// with a raw Go stack trace instead of the clean, reproducible failure
+// this feature exists to produce.
+func stepVM(vm *VM) (halted bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	return vm.Step(), nil
+}
+
+// feedLine makes line available as the VM's next input and steps the VM
+// until it has been fully consumed, halts, or the step budget runs out.
+func feedLine(vm *VM, line string) error {
+	delivered := false
+
+	vm.readLine = func() (string, error) {
+		if delivered {
+			return "", fmt.Errorf("script exhausted: VM requested more input than the script provided")
+		}
+
+		delivered = true
+		return line, nil
+	}
+
+	for steps := 0; ; steps++ {
+		if delivered && vm.input == "" {
+			return nil
+		}
+
+		if steps >= maxScriptSteps {
+			return fmt.Errorf("script step budget exceeded delivering %q", line)
+		}
+
+		halted, err := stepVM(vm)
+
+		if err != nil {
+			return fmt.Errorf("delivering %q: %w", line, err)
+		}
+
+		if halted {
+			if delivered && vm.input == "" {
+				return nil
+			}
+
+			return fmt.Errorf("VM halted before consuming %q", line)
+		}
+	}
+}
+
+// waitFor steps the VM until its pc reaches addr.
+func waitFor(vm *VM, addr uint16) error {
+	for steps := 0; vm.pc != addr; steps++ {
+		if steps >= maxScriptSteps {
+			return fmt.Errorf("!wait %d: never reached (stopped at %d)", addr, vm.pc)
+		}
+
+		halted, err := stepVM(vm)
+
+		if err != nil {
+			return fmt.Errorf("!wait %d: %w", addr, err)
+		}
+
+		if halted {
+			return fmt.Errorf("!wait %d: VM halted at %d before reaching it", addr, vm.pc)
+		}
+	}
+
+	return nil
+}
+
+func assertRegister(vm *VM, args []string) error {
+	if len(args) < 3 || args[0] != "reg" {
+		return fmt.Errorf("usage: !assert reg <n> <val>")
+	}
+
+	n, err := strconv.ParseUint(args[1], 10, 16)
+
+	if err != nil {
+		return fmt.Errorf("!assert reg: bad register %q", args[1])
+	}
+
+	if n >= 8 {
+		return fmt.Errorf("!assert reg: register %d out of range (0-7)", n)
+	}
+
+	want, err := strconv.ParseUint(args[2], 10, 16)
+
+	if err != nil {
+		return fmt.Errorf("!assert reg: bad value %q", args[2])
+	}
+
+	if got := vm.registers[n]; got != uint16(want) {
+		return fmt.Errorf("!assert reg %d %d: got %d", n, want, got)
+	}
+
+	return nil
+}
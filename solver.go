@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+)
+
+// confirmationRoutineAddr is the entry point of the teleporter's
+// confirmation routine, found by following the call graph from the
+// teleporter's "use" handler with `disasm`. It implements an
+// Ackermann-like recurrence over R7 and is expected to leave 6 in R0 when
+// R7 holds the correct value.
+const confirmationRoutineAddr = 6027
+
+// solveTeleporterR7 brute-forces the value of R7 for which the
+// confirmation routine's recurrence, f(4, 1, r7), evaluates to 6. It
+// mirrors the routine's own recursion: f(0,b) = b+1, f(a,0) = f(a-1,r7),
+// f(a,b) = f(a-1, f(a,b-1)), everything mod 32768. The memo table is
+// reset for every r7 since the recurrence's results depend on it.
+func solveTeleporterR7() (uint16, bool) {
+	var cache [5][32768]uint16
+	var computed [5][32768]bool
+
+	for r7 := uint16(1); r7 < 32768; r7++ {
+		for a := range cache {
+			for b := range cache[a] {
+				computed[a][b] = false
+			}
+		}
+
+		if ackermann(4, 1, r7, &cache, &computed) == 6 {
+			return r7, true
+		}
+	}
+
+	return 0, false
+}
+
+func ackermann(a, b, r7 uint16, cache *[5][32768]uint16, computed *[5][32768]bool) uint16 {
+	if a == 0 {
+		return (b + 1) % 32768
+	}
+
+	if computed[a][b] {
+		return cache[a][b]
+	}
+
+	var result uint16
+
+	if b == 0 {
+		result = ackermann(a-1, r7, r7, cache, computed)
+	} else {
+		result = ackermann(a-1, ackermann(a, b-1, r7, cache, computed), r7, cache, computed)
+	}
+
+	cache[a][b] = result
+	computed[a][b] = true
+
+	return result
+}
+
+// findCallSite returns the address of a reachable `call <target>`
+// instruction, walking l.order (the control-flow reachability analysis
+// from disasm.go) rather than scanning raw words - a blind linear scan
+// could mistake a coincidental two-word match sitting in data (the stack
+// area, strings, tables) for a real call site and patch the wrong address.
+func findCallSite(l listing, target uint16) (uint16, bool) {
+	for _, addr := range l.order {
+		inst := l.instructions[addr]
+
+		if inst.opcode == 17 && inst.args[0] == target {
+			return addr, true
+		}
+	}
+
+	return 0, false
+}
+
+// patchTeleporter sets R7 to r7 and short-circuits the confirmation check:
+// the call into the recurrence is expensive (that's the whole reason for
+// solveTeleporterR7 existing), so rather than let it run we NOP out the
+// call and the branch that follows it, and write the expected result (6)
+// directly into R0, which is where the routine leaves its answer.
+func patchTeleporter(vm *VM, r7 uint16) error {
+	l := disassemble(vm.memory.Words())
+
+	callAddr, ok := findCallSite(l, confirmationRoutineAddr)
+
+	if !ok {
+		return fmt.Errorf("could not locate call to confirmation routine at %d", confirmationRoutineAddr)
+	}
+
+	vm.memory.Write(callAddr, 21)   // noop
+	vm.memory.Write(callAddr+1, 21) // noop (call's operand word)
+
+	addr := callAddr + 2
+
+	for {
+		inst, ok := l.instructions[addr]
+
+		if !ok {
+			break
+		}
+
+		if inst.opcode == 7 || inst.opcode == 8 { // jt/jf: the branch gating success
+			for i := uint16(0); i < inst.length; i++ {
+				vm.memory.Write(addr+i, 21) // noop
+			}
+
+			break
+		}
+
+		addr += inst.length
+	}
+
+	vm.registers[0] = 6 // the value the confirmation routine would have returned
+	vm.registers[7] = r7
+
+	return nil
+}
+
+// runSolveTeleporter implements the `solve-teleporter` subcommand: it finds
+// R7, prints it, patches a fresh VM to skip the confirmation check and sets
+// R7 accordingly, then resumes play from address 0 with the patch already
+// in place.
+func runSolveTeleporter() {
+	r7, ok := solveTeleporterR7()
+
+	if !ok {
+		fmt.Println("no value of R7 satisfies the confirmation routine")
+		return
+	}
+
+	fmt.Printf("R7 = %d\n", r7)
+
+	vm := NewVM(readBinary("challenge.bin"))
+
+	if err := patchTeleporter(vm, r7); err != nil {
+		fmt.Printf("patch failed: %v\n", err)
+		return
+	}
+
+	for !vm.Step() {
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// snapshotMagic identifies a VM snapshot file; snapshotVersion lets Load
+// reject blobs written by an incompatible future format.
+const (
+	snapshotMagic   = "SVMS"
+	snapshotVersion = 1
+)
+
+// VM holds the full mutable state of the machine: registers, call stack,
+// memory and program counter, plus the pending input buffer for opcode 20.
+// Keeping these as fields (rather than locals in main) is what lets Save/Load
+// serialize a running machine wholesale.
+type VM struct {
+	registers [8]uint16
+	stack     *stack
+	memory    *Memory
+	pc        uint16
+	input     string
+	debug     bool
+
+	// readLine supplies the next line of input for opcode 20. It defaults
+	// to reading a line from stdin, but a script runner can swap it out to
+	// feed scripted input instead.
+	readLine func() (string, error)
+
+	// recentOutput records everything opcode 19 has written, for the
+	// script runner's `!expect` directive. It's capped at recentOutputCap
+	// bytes so long playthroughs don't grow it unbounded.
+	recentOutput []byte
+}
+
+// recentOutputCap bounds how much of the VM's output history is kept for
+// `!expect` to scan.
+const recentOutputCap = 4096
+
+// NewVM creates a VM ready to execute program from address 0.
+func NewVM(program []uint16) *VM {
+	vm := &VM{
+		stack:  newStack(),
+		memory: NewMemory(program),
+	}
+
+	vm.readLine = vm.readLineFromStdin
+
+	return vm
+}
+
+// Step decodes and executes the instruction at vm.pc, advancing vm.pc.
+// It returns halted=true once the program executes a halt or a ret with
+// an empty stack.
+func (vm *VM) Step() (halted bool) {
+	index := vm.pc
+	vm.memory.SetPC(index)
+
+	a := vm.memory.Read(index + 1)
+	b := vm.memory.Read(index + 2)
+	c := vm.memory.Read(index + 3)
+	op := vm.memory.Read(index)
+
+	if vm.debug {
+		fmt.Printf(
+			"%v: %v %v %v %v (%v %v %v)\n",
+			index,
+			op,
+			a,
+			b,
+			c,
+			vm.getValue(a),
+			vm.getValue(b),
+			vm.getValue(c),
+		)
+	}
+
+	switch op {
+	case 0: // halt: stop execution and terminate the program
+		return true
+	case 1: // set: set register <a> to the value of <b>
+		vm.setRegister(a, b)
+		index += 2
+		break
+	case 2: // push: push <a> onto the stack
+		vm.stack.Push(vm.getValue(a))
+		index++
+		break
+	case 3: // pop: remove the top element from the stack and write it into <a>; empty stack = error
+		value, err := vm.stack.Pop()
+
+		if err != nil {
+			panic(err)
+		}
+
+		vm.setRegister(a, value)
+		index++
+		break
+	case 4: // eq: set <a> to 1 if <b> is equal to <c>; set it to 0 otherwise
+		if vm.getValue(b) == vm.getValue(c) {
+			vm.setRegister(a, 1)
+		} else {
+			vm.setRegister(a, 0)
+		}
+
+		index += 3
+		break
+	case 5: // gt: set <a> to 1 if <b> is greater than <c>; set it to 0 otherwise
+		if vm.getValue(b) > vm.getValue(c) {
+			vm.setRegister(a, 1)
+		} else {
+			vm.setRegister(a, 0)
+		}
+
+		index += 3
+		break
+	case 6: // jmp: jump to <a>
+		index = vm.getValue(a) - 1
+		break
+	case 7: // jt: if <a> is nonzero, jump to <b>
+		if vm.getValue(a) != 0 {
+			index = vm.getValue(b) - 1
+			break
+		}
+
+		index += 2
+		break
+	case 8: // jf: if <a> is zero, jump to <b>
+		if vm.getValue(a) == 0 {
+			index = vm.getValue(b) - 1
+			break
+		}
+
+		index += 2
+		break
+	case 9: // add: assign into <a> the sum of <b> and <c> (modulo 32768)
+		sum := (vm.getValue(b) + vm.getValue(c)) % 32768
+		vm.setRegister(a, sum)
+		index += 3
+		break
+	case 10: // mult: store into <a> the product of <b> and <c> (modulo 32768)
+		value := (vm.getValue(b) * vm.getValue(c)) % 32768
+		vm.setRegister(a, value)
+		index += 3
+		break
+	case 11: // mod: store into <a> the remainder of <b> divided by <c>
+		value := vm.getValue(b) % vm.getValue(c)
+		vm.setRegister(a, value)
+		index += 3
+		break
+	case 12: // and: stores into <a> the bitwise and of <b> and <c>
+		value := (vm.getValue(b) & vm.getValue(c)) % 32768
+		vm.setRegister(a, value)
+		index += 3
+		break
+	case 13: // or: stores into <a> the bitwise or of <b> and <c>
+		value := (vm.getValue(b) | vm.getValue(c)) % 32768
+		vm.setRegister(a, value)
+		index += 3
+		break
+	case 14: // not: stores 15-bit bitwise inverse of <b> in <a>
+		vm.setRegister(a, ^vm.getValue(b)%32768)
+		index += 2
+		break
+	case 15: // rmem: read memory at address <b> and write it to <a>
+		value := vm.memory.Read(vm.getValue(b))
+		vm.setRegisterDirect(a, value)
+		index += 2
+		break
+	case 16: // wmem: write the value from <b> into memory at address <a>
+		address := vm.getValue(a)
+		vm.memory.Write(address, vm.getValue(b))
+		index += 2
+		break
+	case 17: // call: write the address of the next instruction to the stack and jump to <a>
+		vm.stack.Push(index + 2)
+		index = vm.getValue(a) - 1
+		break
+	case 18: // ret: remove the top element from the stack and jump to it; empty stack = halt
+		value, err := vm.stack.Pop()
+
+		if err != nil {
+			return true
+		}
+
+		index = vm.getValue(value) - 1
+		break
+	case 19: // out: write the character represented by ascii code <a> to the terminal
+		ch := vm.getValue(a)
+		fmt.Printf("%c", ch)
+		vm.recordOutput(byte(ch))
+		index++
+		break
+	case 20: // in: read a character from the terminal and write its ascii code to <a>
+		vm.fillInput()
+
+		value := uint16(vm.input[0])
+		vm.input = vm.input[1:]
+
+		vm.setRegister(a, value)
+		index++
+		break
+	case 21: // noop: no operation
+		break
+	default:
+		panic(fmt.Sprintf("unknown opcode \"%v\"", op))
+	}
+
+	index++
+	vm.pc = index
+
+	return false
+}
+
+// recordOutput appends ch to vm.recentOutput, trimming the oldest bytes
+// once it grows past recentOutputCap.
+func (vm *VM) recordOutput(ch byte) {
+	vm.recentOutput = append(vm.recentOutput, ch)
+
+	if over := len(vm.recentOutput) - recentOutputCap; over > 0 {
+		vm.recentOutput = vm.recentOutput[over:]
+	}
+}
+
+// fillInput blocks until vm.input has at least one pending byte, pulling
+// lines from vm.readLine. Lines starting with "!save " or "!load " are
+// intercepted as checkpoint commands rather than fed to the running
+// program, so a player can checkpoint before a risky choice and roll back
+// instantly.
+func (vm *VM) fillInput() {
+	for vm.input == "" {
+		line, err := vm.readLine()
+
+		if err != nil {
+			panic(err)
+		}
+
+		if name, ok := strings.CutPrefix(line, "!save "); ok {
+			vm.saveToFile(strings.TrimSpace(name))
+			continue
+		}
+
+		if name, ok := strings.CutPrefix(line, "!load "); ok {
+			vm.loadFromFile(strings.TrimSpace(name))
+			continue
+		}
+
+		vm.input = line
+	}
+}
+
+// readLineFromStdin is the default vm.readLine: it prompts and reads one
+// line from the terminal, as the original input handling did.
+func (vm *VM) readLineFromStdin() (string, error) {
+	print(":")
+
+	reader := bufio.NewReader(os.Stdin)
+	return reader.ReadString('\n')
+}
+
+func (vm *VM) saveToFile(name string) {
+	file, err := os.Create(name)
+
+	if err != nil {
+		fmt.Printf("save failed: %v\n", err)
+		return
+	}
+
+	defer file.Close()
+
+	if err := vm.Save(file); err != nil {
+		fmt.Printf("save failed: %v\n", err)
+		return
+	}
+
+	fmt.Printf("saved to %v\n", name)
+}
+
+func (vm *VM) loadFromFile(name string) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		fmt.Printf("load failed: %v\n", err)
+		return
+	}
+
+	defer file.Close()
+
+	if err := vm.Load(file); err != nil {
+		fmt.Printf("load failed: %v\n", err)
+		return
+	}
+
+	fmt.Printf("loaded %v\n", name)
+}
+
+// Save serializes the entire VM state - registers, stack, memory, pc and
+// the pending input buffer - to w as a compact little-endian blob behind a
+// magic header and version byte.
+func (vm *VM) Save(w io.Writer) error {
+	if _, err := w.Write([]byte(snapshotMagic)); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint8(snapshotVersion)); err != nil {
+		return err
+	}
+
+	for _, r := range vm.registers {
+		if err := binary.Write(w, binary.LittleEndian, r); err != nil {
+			return err
+		}
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, vm.pc); err != nil {
+		return err
+	}
+
+	if err := writeUint16Slice(w, vm.stack.Snapshot()); err != nil {
+		return err
+	}
+
+	if err := writeUint16Slice(w, vm.memory.Words()); err != nil {
+		return err
+	}
+
+	return writeString(w, vm.input)
+}
+
+// Load replaces vm's state with the snapshot read from r, as produced by Save.
+func (vm *VM) Load(r io.Reader) error {
+	magic := make([]byte, len(snapshotMagic))
+
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return err
+	}
+
+	if string(magic) != snapshotMagic {
+		return fmt.Errorf("not a VM snapshot")
+	}
+
+	var version uint8
+
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return err
+	}
+
+	if version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	var registers [8]uint16
+
+	for i := range registers {
+		if err := binary.Read(r, binary.LittleEndian, &registers[i]); err != nil {
+			return err
+		}
+	}
+
+	var pc uint16
+
+	if err := binary.Read(r, binary.LittleEndian, &pc); err != nil {
+		return err
+	}
+
+	stackWords, err := readUint16Slice(r)
+
+	if err != nil {
+		return err
+	}
+
+	memoryWords, err := readUint16Slice(r)
+
+	if err != nil {
+		return err
+	}
+
+	input, err := readString(r)
+
+	if err != nil {
+		return err
+	}
+
+	vm.registers = registers
+	vm.pc = pc
+	vm.stack.Restore(stackWords)
+	vm.memory = NewMemory(memoryWords)
+	vm.input = input
+
+	return nil
+}
+
+func writeUint16Slice(w io.Writer, s []uint16) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(s))); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, s)
+}
+
+func readUint16Slice(r io.Reader) ([]uint16, error) {
+	var length uint32
+
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+
+	s := make([]uint16, length)
+
+	if err := binary.Read(r, binary.LittleEndian, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(s))); err != nil {
+		return err
+	}
+
+	_, err := w.Write([]byte(s))
+	return err
+}
+
+func readString(r io.Reader) (string, error) {
+	var length uint32
+
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, length)
+
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func (vm *VM) getValue(value uint16) uint16 {
+	return getValue(vm.registers[:], value)
+}
+
+func (vm *VM) setRegister(register uint16, value uint16) {
+	setRegister(vm.registers[:], register, value)
+}
+
+// setRegisterDirect stores value in register as-is, without resolving it
+// through getValue first. setRegister resolves its value argument because
+// most opcodes pass it a raw instruction operand (itself possibly a
+// register reference); rmem instead passes an already-fetched memory word,
+// which must be stored verbatim - re-resolving it was the rmem bug.
+func (vm *VM) setRegisterDirect(register uint16, value uint16) {
+	vm.registers[register-32768] = value
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestRmemReadsPlainMemory is a regression test for a bug where rmem did
+// getValue(registers, program[getValue(registers, b)]) - treating the value
+// read from memory as a register/literal to resolve again - instead of
+// simply returning it. rmem must always load straight from memory.
+func TestRmemReadsPlainMemory(t *testing.T) {
+	// rmem R0, 10 -> read memory[10] into R0
+	vm := NewVM([]uint16{15, 32768, 10})
+	vm.memory.Write(10, 32768+3) // a value that looks like a register reference
+
+	if halted := vm.Step(); halted {
+		t.Fatalf("VM halted unexpectedly")
+	}
+
+	if got := vm.registers[0]; got != 32768+3 {
+		t.Errorf("R0 = %d, want %d (the raw memory value, not re-resolved)", got, 32768+3)
+	}
+}
+
+func TestMemoryReadWriteAndClone(t *testing.T) {
+	m := NewMemory([]uint16{1, 2, 3})
+
+	if got := m.Read(1); got != 2 {
+		t.Fatalf("Read(1) = %d, want 2", got)
+	}
+
+	clone := m.Clone()
+	clone.Write(1, 99)
+
+	if got := m.Read(1); got != 2 {
+		t.Errorf("writing to clone mutated original: Read(1) = %d, want 2", got)
+	}
+
+	if got := clone.Read(1); got != 99 {
+		t.Errorf("clone.Read(1) = %d, want 99", got)
+	}
+}
+
+// TestSaveLoadRoundTrip exercises the snapshot format end to end: a VM with
+// non-trivial register, stack, memory, pc and pending-input state is saved
+// and reloaded into a fresh VM, which should come out identical.
+func TestSaveLoadRoundTrip(t *testing.T) {
+	vm := NewVM([]uint16{9, 32768, 1, 2})
+	vm.registers = [8]uint16{1, 2, 3, 4, 5, 6, 7, 8}
+	vm.stack.Push(10)
+	vm.stack.Push(20)
+	vm.memory.Write(100, 42)
+	vm.pc = 3
+	vm.input = "abc"
+
+	var buf bytes.Buffer
+
+	if err := vm.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded := NewVM(nil)
+
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if loaded.registers != vm.registers {
+		t.Errorf("registers = %v, want %v", loaded.registers, vm.registers)
+	}
+
+	if !reflect.DeepEqual(loaded.stack.Snapshot(), vm.stack.Snapshot()) {
+		t.Errorf("stack = %v, want %v", loaded.stack.Snapshot(), vm.stack.Snapshot())
+	}
+
+	if !reflect.DeepEqual(loaded.memory.Words(), vm.memory.Words()) {
+		t.Errorf("memory did not round-trip")
+	}
+
+	if loaded.pc != vm.pc {
+		t.Errorf("pc = %d, want %d", loaded.pc, vm.pc)
+	}
+
+	if loaded.input != vm.input {
+		t.Errorf("input = %q, want %q", loaded.input, vm.input)
+	}
+}
+
+func TestMemoryOutOfRangeFault(t *testing.T) {
+	m := NewMemory(nil)
+
+	defer func() {
+		r := recover()
+
+		fault, ok := r.(MemoryFault)
+
+		if !ok {
+			t.Fatalf("expected a MemoryFault panic, got %v", r)
+		}
+
+		if fault.Addr != memorySize {
+			t.Errorf("fault.Addr = %d, want %d", fault.Addr, memorySize)
+		}
+	}()
+
+	m.Read(memorySize)
+}